@@ -0,0 +1,50 @@
+/*
+Copyright 2024 Christoph Raitzig
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command webdav-server serves a local directory over WebDAV. It is useful
+// for exercising this plugin in CI or in an air-gapped cluster without
+// standing up a separate WebDAV deployment.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/Talinx/velero-plugin-for-webdav/internal/plugin/server"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory to serve via WebDAV")
+	addr := flag.String("addr", ":8080", "address to listen on")
+	user := flag.String("user", "", "HTTP basic auth username (auth is disabled only if -user and -password are both empty)")
+	password := flag.String("password", "", "HTTP basic auth password (auth is disabled only if -user and -password are both empty)")
+	readOnly := flag.Bool("readonly", false, "reject any request that would modify the served directory")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file, enables TLS together with -tls-key")
+	tlsKey := flag.String("tls-key", "", "PEM key file, enables TLS together with -tls-cert")
+	flag.Parse()
+
+	srv := server.New(server.Config{
+		Dir:      *dir,
+		Username: *user,
+		Password: *password,
+		ReadOnly: *readOnly,
+		TLSCert:  *tlsCert,
+		TLSKey:   *tlsKey,
+	})
+
+	log.Printf("Serving '%s' via WebDAV on %s", *dir, *addr)
+	log.Fatal(srv.ListenAndServe(*addr))
+}
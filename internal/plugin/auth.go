@@ -0,0 +1,280 @@
+/*
+Copyright 2024 Christoph Raitzig
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// Supported values for the authMode config key.
+const (
+	authModeBasic  = "basic"
+	authModeDigest = "digest"
+	authModeBearer = "bearer"
+	authModeMTLS   = "mtls"
+)
+
+// newUnderlyingClient builds the gowebdav.Client appropriate for w.authMode.
+// Basic and digest auth are handled by gowebdav's own Authenticator; bearer
+// and mTLS are not natively supported by gowebdav, so those are carried
+// instead by the http.RoundTripper returned by requestTransport.
+func (w *WebDAVObjectStore) newUnderlyingClient() *gowebdav.Client {
+	switch w.authMode {
+	case authModeDigest:
+		// NewAutoAuth negotiates whichever of Basic/Digest the server challenges for.
+		return gowebdav.NewAuthClient(w.root, gowebdav.NewAutoAuth(w.user, w.password))
+	case authModeBearer, authModeMTLS:
+		return gowebdav.NewClient(w.root, w.user, w.password)
+	default:
+		return gowebdav.NewClient(w.root, w.user, w.password)
+	}
+}
+
+// requestTransport returns the http.RoundTripper to use for both the pooled
+// gowebdav.Client and the raw PROPFIND requests in listall.go. For bearer
+// auth this wraps the shared transport to attach the Authorization header;
+// all other modes use the shared transport (with TLS client-cert/CA
+// configuration already baked in by buildTransport) directly.
+func (w *WebDAVObjectStore) requestTransport() http.RoundTripper {
+	if w.authMode == authModeBearer {
+		return &bearerRoundTripper{token: w.bearerToken, base: w.transport}
+	}
+	return w.transport
+}
+
+// buildTransport constructs the shared http.Transport, applying mTLS client
+// certificates, a custom CA bundle, and insecureSkipVerify where configured.
+func (w *WebDAVObjectStore) buildTransport() (*http.Transport, error) {
+	transport := &http.Transport{
+		MaxIdleConns:        w.maxIdleConns,
+		MaxIdleConnsPerHost: w.maxIdleConnsPerHost,
+		IdleConnTimeout:     w.idleConnTimeout,
+	}
+
+	if w.authMode != authModeMTLS && w.caCertPath == "" && !w.insecureSkipVerify {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: w.insecureSkipVerify}
+
+	if w.authMode == authModeMTLS {
+		cert, err := tls.LoadX509KeyPair(w.clientCertPath, w.clientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate/key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if w.caCertPath != "" {
+		caCert, err := os.ReadFile(w.caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in '%s'", w.caCertPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// doAuthenticated issues req through an http.Client on the shared transport, authenticating
+// it the way w.authMode requires. This is what listall.go and chunked.go use
+// for the raw PROPFIND/PUT/HEAD requests that gowebdav.Client has no method
+// for - those requests do not go through the pooled gowebdav.Client, so they
+// need their own auth handling instead of relying on gowebdav's.
+func (w *WebDAVObjectStore) doAuthenticated(req *http.Request) (*http.Response, error) {
+	client := w.httpClient()
+
+	if w.authMode != authModeDigest {
+		// basic and mtls authenticate with a plain Basic header; bearer is
+		// attached by requestTransport's RoundTripper.
+		if w.authMode == authModeBasic || w.authMode == authModeMTLS {
+			req.SetBasicAuth(w.user, w.password)
+		}
+		return client.Do(req)
+	}
+
+	// Reuse a challenge cached from an earlier call, if any, so most digest
+	// requests authenticate in a single round trip instead of paying for a
+	// 401 challenge/response dance before every PROPFIND, chunk PUT, or HEAD.
+	if header, ok := w.nextDigestHeader(req.Method, req.URL.RequestURI()); ok {
+		req.Header.Set("Authorization", header)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if !strings.HasPrefix(challenge, "Digest ") {
+		// not a digest challenge, nothing more we can do; req's body was
+		// already consumed by the client.Do above, so get a fresh reader
+		// before resending it
+		if err := refreshRequestBody(req); err != nil {
+			return nil, err
+		}
+		return client.Do(req)
+	}
+
+	// Either this was the first digest request (nothing cached yet) or the
+	// cached nonce just went stale; negotiate fresh and cache it so
+	// subsequent calls can skip straight to the header above.
+	w.cacheDigestChallenge(challenge)
+	header, _ := w.nextDigestHeader(req.Method, req.URL.RequestURI())
+
+	retry := req.Clone(req.Context())
+	if err := refreshRequestBody(retry); err != nil {
+		return nil, err
+	}
+	retry.Header.Set("Authorization", header)
+	return client.Do(retry)
+}
+
+// refreshRequestBody replaces req.Body with a fresh reader from req.GetBody,
+// for retrying a request whose body was already consumed by an earlier
+// attempt. It is a no-op for requests with no body to refresh.
+func refreshRequestBody(req *http.Request) error {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+// cacheDigestChallenge records a WWW-Authenticate challenge and resets the
+// nonce-count, so nextDigestHeader can build Authorization headers for it
+// without re-challenging the server on every call.
+func (w *WebDAVObjectStore) cacheDigestChallenge(challenge string) {
+	w.digestMu.Lock()
+	defer w.digestMu.Unlock()
+	w.digestParams = parseDigestChallenge(challenge)
+	w.digestNC = 0
+}
+
+// nextDigestHeader builds an Authorization header from the cached digest
+// challenge, incrementing its nonce-count (nc) so repeated calls reuse the
+// same nonce instead of re-challenging the server every time. ok is false if
+// no challenge has been cached yet (or the cached one turned out unusable).
+func (w *WebDAVObjectStore) nextDigestHeader(method, uri string) (header string, ok bool) {
+	w.digestMu.Lock()
+	defer w.digestMu.Unlock()
+	if w.digestParams == nil {
+		return "", false
+	}
+	w.digestNC++
+	header, err := buildDigestAuthHeader(w.digestParams, w.user, w.password, method, uri, w.digestNC)
+	if err != nil {
+		return "", false
+	}
+	return header, true
+}
+
+// buildDigestAuthHeader computes an RFC 2617 Digest Authorization header
+// (MD5, qop=auth where offered) for the given nonce-count against an
+// already-parsed WWW-Authenticate challenge.
+func buildDigestAuthHeader(params map[string]string, username, password, method, uri string, nc uint64) (string, error) {
+	realm := params["realm"]
+	nonce := params["nonce"]
+	if nonce == "" {
+		return "", fmt.Errorf("digest challenge is missing a nonce")
+	}
+	qop := params["qop"]
+
+	ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", username, realm, password))
+	ha2 := md5Hex(fmt.Sprintf("%s:%s", method, uri))
+
+	var response, cnonce, ncStr string
+	if strings.Contains(qop, "auth") {
+		qop = "auth"
+		ncStr = fmt.Sprintf("%08x", nc)
+		var err error
+		cnonce, err = generateCnonce()
+		if err != nil {
+			return "", err
+		}
+		response = md5Hex(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, nonce, ncStr, cnonce, qop, ha2))
+	} else {
+		qop = ""
+		response = md5Hex(fmt.Sprintf("%s:%s:%s", ha1, nonce, ha2))
+	}
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`, username, realm, nonce, uri, response)
+	if qop != "" {
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, ncStr, cnonce)
+	}
+	if opaque := params["opaque"]; opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return header, nil
+}
+
+func parseDigestChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Digest "), ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	return params
+}
+
+func generateCnonce() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerRoundTripper attaches a static bearer token to every request, for
+// servers behind an OAuth-bearer reverse proxy.
+type bearerRoundTripper struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *bearerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", t.token))
+	return t.base.RoundTrip(req)
+}
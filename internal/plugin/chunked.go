@@ -0,0 +1,322 @@
+/*
+Copyright 2024 Christoph Raitzig
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// chunkManifest is the small JSON file written alongside the in-progress
+// chunks of an upload, so a retried PutObject can resume after the last
+// chunk that was both fully uploaded and verified.
+type chunkManifest struct {
+	ChunkSHA256 []string `json:"chunkSha256"`
+	ChunkSizes  []int64  `json:"chunkSizes"`
+	TotalSize   int64    `json:"totalSize"`
+}
+
+func chunkPartDir(path string) string {
+	return fmt.Sprintf("%s.part/", path)
+}
+
+func chunkManifestPath(path string) string {
+	return fmt.Sprintf("%smanifest.json", chunkPartDir(path))
+}
+
+func chunkFilePath(path string, index int) string {
+	return fmt.Sprintf("%s%05d", chunkPartDir(path), index)
+}
+
+// putObjectChunked uploads body to path in chunkSizeBytes pieces, each with
+// its own Content-Range PUT, recording progress in a manifest so a retried
+// upload can resume instead of restarting from byte zero.
+func (w *WebDAVObjectStore) putObjectChunked(c *gowebdav.Client, path string, body io.Reader) error {
+	partDir := chunkPartDir(path)
+	manifest := chunkManifest{}
+
+	if err := c.MkdirAll(partDir, 0755); err != nil {
+		return err
+	}
+
+	if resumed, err := w.loadChunkManifest(c, chunkManifestPath(path)); err == nil {
+		verified, err := w.verifyResumableChunks(c, path, resumed)
+		if err != nil {
+			return fmt.Errorf("verifying already-uploaded chunks of '%s': %w", path, err)
+		}
+		manifest = verified
+		if manifest.TotalSize > 0 {
+			w.log.Infof("Resuming chunked upload of '%s' after %d verified bytes (%d chunks already uploaded)", path, manifest.TotalSize, len(manifest.ChunkSHA256))
+			if _, err := io.CopyN(io.Discard, body, manifest.TotalSize); err != nil {
+				return fmt.Errorf("replaying already-uploaded bytes of '%s': %w", path, err)
+			}
+		}
+	} else if !isNotFound(err) {
+		w.log.Warnf("Could not read chunk manifest for '%s' (%v), starting upload from scratch", path, err)
+	}
+
+	buf := make([]byte, w.chunkSizeBytes)
+	for {
+		n, readErr := io.ReadFull(body, buf)
+		if readErr != nil && readErr != io.ErrUnexpectedEOF && readErr != io.EOF {
+			return readErr
+		}
+		if n == 0 {
+			break
+		}
+		chunkData := buf[:n]
+		index := len(manifest.ChunkSHA256)
+
+		if err := w.putChunk(chunkFilePath(path, index), chunkData, manifest.TotalSize); err != nil {
+			return fmt.Errorf("uploading chunk %d of '%s': %w", index, path, err)
+		}
+
+		chunkHash := sha256.Sum256(chunkData)
+		manifest.ChunkSHA256 = append(manifest.ChunkSHA256, hex.EncodeToString(chunkHash[:]))
+		manifest.ChunkSizes = append(manifest.ChunkSizes, int64(n))
+		manifest.TotalSize += int64(n)
+
+		if err := w.saveChunkManifest(c, chunkManifestPath(path), manifest); err != nil {
+			return fmt.Errorf("saving chunk manifest for '%s': %w", path, err)
+		}
+
+		if readErr == io.ErrUnexpectedEOF || readErr == io.EOF {
+			break
+		}
+	}
+
+	if len(manifest.ChunkSHA256) == 0 {
+		// body was empty - there is nothing to chunk, just write the empty object directly
+		if err := c.WriteStream(path, bytes.NewReader(nil), 0755); err != nil {
+			return err
+		}
+		if err := c.RemoveAll(partDir); err != nil {
+			w.log.Warnf("Could not remove chunk directory '%s' after writing empty object '%s': %v", partDir, path, err)
+		}
+		return nil
+	}
+
+	if err := w.assembleChunks(c, path, manifest); err != nil {
+		return fmt.Errorf("assembling chunks of '%s': %w", path, err)
+	}
+
+	if err := c.RemoveAll(partDir); err != nil {
+		w.log.Warnf("Could not remove chunk directory '%s' after assembling '%s': %v", partDir, path, err)
+	}
+
+	return w.verifyUploadSize(path, manifest.TotalSize)
+}
+
+// putChunk uploads one chunk with a Content-Range header describing where it
+// belongs in the final object, so the server-visible .part files stay
+// self-describing even without the manifest.
+func (w *WebDAVObjectStore) putChunk(chunkPath string, data []byte, offset int64) error {
+	req, err := http.NewRequest(http.MethodPut, joinURL(w.root, chunkPath), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, offset+int64(len(data))-1))
+
+	resp, err := w.doAuthenticated(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// assembleChunks combines the uploaded chunks into the final object. Every
+// chunk's SHA-256 is re-verified against the manifest: a single-chunk upload
+// is read back once to confirm its hash before it is assembled with a cheap
+// server-side MOVE; anything larger has to be streamed back through a
+// concatenating PUT anyway, since plain WebDAV has no multi-source assembly
+// verb, so the read-back there does double duty as the verification pass. In
+// that case a chunk is checked once it has been fully streamed into the PUT,
+// and a mismatch fails the PUT with an error.
+func (w *WebDAVObjectStore) assembleChunks(c *gowebdav.Client, path string, manifest chunkManifest) error {
+	chunkCount := len(manifest.ChunkSHA256)
+	if chunkCount == 1 {
+		if err := verifyChunkChecksum(c, chunkFilePath(path, 0), manifest.ChunkSHA256[0]); err != nil {
+			return err
+		}
+		return c.Rename(chunkFilePath(path, 0), path, true)
+	}
+
+	return c.WriteStream(path, &chunkSequenceReader{c: c, path: path, manifest: manifest}, 0755)
+}
+
+// verifyChunkChecksum reads chunkPath back from the server and confirms its
+// SHA-256 matches expectedHash, discarding the data as it is hashed.
+func verifyChunkChecksum(c *gowebdav.Client, chunkPath, expectedHash string) error {
+	rc, err := c.ReadStream(chunkPath)
+	if err != nil {
+		return err
+	}
+	hr := &checksumVerifyingReader{ReadCloser: rc, hasher: sha256.New(), expectedHash: expectedHash, path: chunkPath}
+	_, err = io.Copy(io.Discard, hr)
+	closeErr := hr.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// verifyResumableChunks re-reads and re-hashes each chunk recorded in a
+// resumed manifest against the server, truncating the manifest at the first
+// chunk that is missing or no longer matches its recorded checksum. This
+// keeps a resumed upload from trusting manifest.TotalSize blindly and
+// skipping re-upload of a chunk that was lost or corrupted between attempts.
+func (w *WebDAVObjectStore) verifyResumableChunks(c *gowebdav.Client, path string, manifest chunkManifest) (chunkManifest, error) {
+	var verified chunkManifest
+	for i, expectedHash := range manifest.ChunkSHA256 {
+		chunkPath := chunkFilePath(path, i)
+		if err := verifyChunkChecksum(c, chunkPath, expectedHash); err != nil {
+			if !isNotFound(err) {
+				w.log.Warnf("Chunk %d of '%s' failed verification on resume (%v), re-uploading from there", i, path, err)
+			}
+			break
+		}
+		verified.ChunkSHA256 = append(verified.ChunkSHA256, expectedHash)
+		verified.ChunkSizes = append(verified.ChunkSizes, manifest.ChunkSizes[i])
+		verified.TotalSize += manifest.ChunkSizes[i]
+	}
+	return verified, nil
+}
+
+// chunkSequenceReader reads the uploaded chunks of path back in order,
+// opening each one only once the previous chunk has been fully consumed, and
+// verifying every chunk's SHA-256 against the manifest as it is read.
+type chunkSequenceReader struct {
+	c        *gowebdav.Client
+	path     string
+	manifest chunkManifest
+	index    int
+	current  io.ReadCloser
+}
+
+func (r *chunkSequenceReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.index >= len(r.manifest.ChunkSHA256) {
+				return 0, io.EOF
+			}
+			rc, err := r.c.ReadStream(chunkFilePath(r.path, r.index))
+			if err != nil {
+				return 0, err
+			}
+			r.current = &checksumVerifyingReader{
+				ReadCloser:   rc,
+				hasher:       sha256.New(),
+				expectedHash: r.manifest.ChunkSHA256[r.index],
+				path:         chunkFilePath(r.path, r.index),
+			}
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			closeErr := r.current.Close()
+			r.current = nil
+			r.index++
+			if n > 0 {
+				return n, nil
+			}
+			if closeErr != nil {
+				return 0, closeErr
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// checksumVerifyingReader hashes every byte read through it and, once the
+// underlying reader is exhausted, reports a mismatch against expectedHash as
+// an error instead of a clean EOF.
+type checksumVerifyingReader struct {
+	io.ReadCloser
+	hasher       hash.Hash
+	expectedHash string
+	path         string
+}
+
+func (c *checksumVerifyingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		c.hasher.Write(p[:n])
+	}
+	if err == io.EOF {
+		if got := hex.EncodeToString(c.hasher.Sum(nil)); got != c.expectedHash {
+			return n, fmt.Errorf("chunk '%s' checksum mismatch: expected %s, got %s", c.path, c.expectedHash, got)
+		}
+	}
+	return n, err
+}
+
+// verifyUploadSize performs a HEAD request against the assembled object and
+// compares its reported Content-Length to the size that was uploaded, as a
+// cheap integrity check since plain WebDAV exposes no content checksum.
+func (w *WebDAVObjectStore) verifyUploadSize(path string, expectedSize int64) error {
+	req, err := http.NewRequest(http.MethodHead, joinURL(w.root, path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.doAuthenticated(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength >= 0 && resp.ContentLength != expectedSize {
+		return fmt.Errorf("uploaded '%s' but server reports size %d, expected %d", path, resp.ContentLength, expectedSize)
+	}
+	return nil
+}
+
+func (w *WebDAVObjectStore) loadChunkManifest(c *gowebdav.Client, manifestPath string) (chunkManifest, error) {
+	var manifest chunkManifest
+	rc, err := c.ReadStream(manifestPath)
+	if err != nil {
+		return manifest, err
+	}
+	defer rc.Close()
+
+	if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return manifest, err
+	}
+	return manifest, nil
+}
+
+func (w *WebDAVObjectStore) saveChunkManifest(c *gowebdav.Client, manifestPath string, manifest chunkManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return c.WriteStream(manifestPath, bytes.NewReader(data), 0644)
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2024 Christoph Raitzig
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// FileEntry describes one resource found while listing a directory tree.
+// Path is relative to the WebDAV root (the same space as the bucket/key
+// paths used throughout this package) and, matching the existing convention
+// for such paths in this file, always ends in "/" - even for files.
+type FileEntry struct {
+	Path    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+const propfindAllpropBody = `<?xml version="1.0" encoding="utf-8" ?>
+<D:propfind xmlns:D="DAV:">
+  <D:allprop/>
+</D:propfind>`
+
+// ListAllUnder recursively lists everything below path. When the server
+// supports it, this is done with a single PROPFIND Depth: infinity request;
+// otherwise (or if that request fails, e.g. because the server rejects
+// infinite depth like many Nextcloud configurations do) it falls back to the
+// previous one-PROPFIND-per-directory strategy. A not-found reply to the
+// infinity request is returned as is, without falling back.
+func (w *WebDAVObjectStore) ListAllUnder(path string) ([]FileEntry, error) {
+	if w.depthInfinitySupported {
+		entries, err := w.propfindInfinity(path)
+		if err == nil {
+			return entries, nil
+		}
+		if isNotFound(err) {
+			return nil, err
+		}
+		w.log.Warnf("PROPFIND with Depth: infinity against '%s' failed (%v), falling back to per-directory listing", path, err)
+	}
+
+	var entries []FileEntry
+	err := w.withRetry(func(c *gowebdav.Client) error {
+		var err error
+		entries, err = listAllRecursive(c, path)
+		return err
+	})
+	return entries, err
+}
+
+// listAllRecursive is the fallback strategy: one PROPFIND (via ReadDir) per
+// directory, walked depth-first.
+func listAllRecursive(c *gowebdav.Client, dir string) ([]FileEntry, error) {
+	children, err := c.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []FileEntry
+	for _, child := range children {
+		completePath := fmt.Sprintf("%s%s/", dir, child.Name())
+		entries = append(entries, FileEntry{
+			Path:    completePath,
+			IsDir:   child.IsDir(),
+			Size:    child.Size(),
+			ModTime: child.ModTime(),
+		})
+		if child.IsDir() {
+			subEntries, err := listAllRecursive(c, completePath)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, subEntries...)
+		}
+	}
+	return entries, nil
+}
+
+// propfindInfinity issues a single PROPFIND with Depth: infinity against
+// path and streams the multistatus XML response into a flat []FileEntry
+// without buffering the whole document in memory.
+func (w *WebDAVObjectStore) propfindInfinity(path string) ([]FileEntry, error) {
+	req, err := http.NewRequest("PROPFIND", joinURL(w.root, path), strings.NewReader(propfindAllpropBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "infinity")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := w.doAuthenticated(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("PROPFIND '%s': %w", path, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND '%s' with Depth: infinity returned status %d", path, resp.StatusCode)
+	}
+
+	return decodeMultistatus(resp.Body, w.root, path)
+}
+
+func joinURL(root, path string) string {
+	root = strings.TrimSuffix(root, "/")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return root + path
+}
+
+// davMultistatusResponse is one <D:response> element of a multistatus
+// PROPFIND reply. Only the properties this package needs are decoded.
+type davMultistatusResponse struct {
+	Href     string `xml:"href"`
+	Propstat []struct {
+		Status string `xml:"status"`
+		Prop   struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+			ContentLength int64  `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+		} `xml:"prop"`
+	} `xml:"propstat"`
+}
+
+// decodeMultistatus streams a PROPFIND multistatus response one <D:response>
+// element at a time, turning each into a FileEntry relative to root.
+func decodeMultistatus(body io.Reader, root, requestPath string) ([]FileEntry, error) {
+	rootURL, err := url.Parse(root)
+	if err != nil {
+		return nil, err
+	}
+	rootPath := strings.TrimSuffix(rootURL.Path, "/")
+	selfPath := strings.TrimSuffix(fmt.Sprintf("%s/%s", rootPath, strings.TrimPrefix(requestPath, "/")), "/")
+
+	decoder := xml.NewDecoder(body)
+	var entries []FileEntry
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "response" {
+			continue
+		}
+
+		var response davMultistatusResponse
+		if err := decoder.DecodeElement(&response, &start); err != nil {
+			return nil, err
+		}
+		entry, ok, err := response.toFileEntry(rootPath, selfPath)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (r davMultistatusResponse) toFileEntry(rootPath, selfPath string) (FileEntry, bool, error) {
+	hrefPath, err := url.PathUnescape(r.Href)
+	if err != nil {
+		return FileEntry{}, false, err
+	}
+	hrefPath = strings.TrimSuffix(hrefPath, "/")
+	if hrefPath == selfPath {
+		// the root of the PROPFIND is included in its own response, skip it
+		return FileEntry{}, false, nil
+	}
+
+	for _, propstat := range r.Propstat {
+		if !strings.Contains(propstat.Status, "200") {
+			continue
+		}
+		relPath := strings.TrimPrefix(strings.TrimPrefix(hrefPath, rootPath), "/")
+		modTime, _ := time.Parse(time.RFC1123, propstat.Prop.LastModified)
+		return FileEntry{
+			Path:    fmt.Sprintf("%s/", relPath),
+			IsDir:   propstat.Prop.ResourceType.Collection != nil,
+			Size:    propstat.Prop.ContentLength,
+			ModTime: modTime,
+		}, true, nil
+	}
+	return FileEntry{}, false, nil
+}
+
+// immediateParentDir returns the directory path ("a/b/") that directly
+// contains the given file path ("a/b/c.txt"), or "" if the file has no
+// parent (it sits at the root).
+func immediateParentDir(filePath string) string {
+	trimmed := strings.TrimSuffix(filePath, "/")
+	idx := strings.LastIndex(trimmed, "/")
+	if idx < 0 {
+		return ""
+	}
+	return trimmed[:idx+1]
+}
@@ -20,14 +20,31 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/studio-b12/gowebdav"
 )
 
+// Defaults for the pooled HTTP transport used by the WebDAV client. These
+// mirror the values other gowebdav-based backup tools ship with and keep a
+// single backup/restore run from re-negotiating TLS and auth on every call.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultTimeout             = 30 * time.Second
+
+	// defaultChunkSizeBytes is used for chunked uploads unless chunkSizeBytes
+	// is set in the config. A chunkSizeBytes of 0 disables chunking.
+	defaultChunkSizeBytes = 64 * 1024 * 1024
+)
+
 type WebDAVObjectStore struct {
 	log        logrus.FieldLogger
 	root       string
@@ -36,17 +53,44 @@ type WebDAVObjectStore struct {
 	bucketsDir string // bucketsDir ends in / or is empty
 	logLevel   string
 	delimiter  string
+
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	timeout             time.Duration
+	transport           *http.Transport
+
+	depthInfinitySupported bool
+
+	authMode           string
+	bearerToken        string
+	clientCertPath     string
+	clientKeyPath      string
+	caCertPath         string
+	insecureSkipVerify bool
+
+	chunkSizeBytes int64
+
+	clientMu sync.Mutex
+	client   *gowebdav.Client
+
+	// digestMu guards the cached Digest challenge used by doAuthenticated so
+	// the raw PROPFIND/PUT/HEAD requests in listall.go and chunked.go don't
+	// pay for a 401 challenge/response round trip before every call.
+	digestMu     sync.Mutex
+	digestParams map[string]string
+	digestNC     uint64
 }
 
 func NewWebDAVObjectStore(log logrus.FieldLogger) *WebDAVObjectStore {
 	return &WebDAVObjectStore{log: log}
 }
 
-func (w WebDAVObjectStore) PrintInfos() bool {
+func (w *WebDAVObjectStore) PrintInfos() bool {
 	return w.logLevel == "INFO" || w.logLevel == "DEBUG"
 }
 
-func (w WebDAVObjectStore) PrintWarnings() bool {
+func (w *WebDAVObjectStore) PrintWarnings() bool {
 	return w.logLevel == "" || w.logLevel == "WARN" || w.logLevel == "INFO" || w.logLevel == "DEBUG"
 }
 
@@ -65,6 +109,36 @@ func (w *WebDAVObjectStore) Init(config map[string]string) error {
 	w.user = user
 	w.password = password
 	w.delimiter = delimiter
+
+	w.maxIdleConns = parseIntConfig(config["maxIdleConns"], defaultMaxIdleConns)
+	w.maxIdleConnsPerHost = parseIntConfig(config["maxIdleConnsPerHost"], defaultMaxIdleConnsPerHost)
+	w.idleConnTimeout = parseDurationConfig(config["idleConnTimeout"], defaultIdleConnTimeout)
+	w.timeout = parseDurationConfig(config["timeout"], defaultTimeout)
+	w.depthInfinitySupported = parseBoolConfig(config["depthInfinitySupported"], true)
+	w.chunkSizeBytes = parseInt64Config(config["chunkSizeBytes"], defaultChunkSizeBytes)
+
+	w.authMode = strings.ToLower(config["authMode"])
+	if w.authMode == "" {
+		w.authMode = authModeBasic
+	}
+	w.bearerToken = config["bearerToken"]
+	w.clientCertPath = config["clientCertPath"]
+	w.clientKeyPath = config["clientKeyPath"]
+	w.caCertPath = config["caCertPath"]
+	w.insecureSkipVerify = parseBoolConfig(config["insecureSkipVerify"], false)
+	switch w.authMode {
+	case authModeBasic, authModeDigest, authModeBearer, authModeMTLS:
+	default:
+		w.log.Errorf("Unknown authMode '%s', falling back to basic auth", w.authMode)
+		w.authMode = authModeBasic
+	}
+
+	transport, err := w.buildTransport()
+	if err != nil {
+		w.log.Errorf("Error configuring WebDAV transport: %v", err)
+		return err
+	}
+	w.transport = transport
 	if bucketsDir != "" && !strings.HasPrefix(bucketsDir, "/") {
 		bucketsDir = fmt.Sprintf("%s/", bucketsDir)
 	}
@@ -93,127 +167,189 @@ func (w *WebDAVObjectStore) Init(config map[string]string) error {
 	return nil
 }
 
-func SplitPathToDirAndFilename(path string) (dir string, name string) {
-	lastSeparatorI := strings.LastIndex(path, "/")
-	dir, name = "", path
-	if lastSeparatorI != -1 {
-		dir, name = path[:lastSeparatorI], path[lastSeparatorI+1:]
+func parseIntConfig(value string, fallback int) int {
+	if value == "" {
+		return fallback
 	}
-	return dir, name
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
 }
 
-func (w *WebDAVObjectStore) PutObject(bucket string, key string, body io.Reader) error {
-	path := fmt.Sprintf("%s%s%s%s", w.bucketsDir, bucket, w.delimiter, key)
-	dir, _ := SplitPathToDirAndFilename(path)
-
-	c := gowebdav.NewClient(w.root, w.user, w.password)
-	err := c.Connect()
+func parseInt64Config(value string, fallback int64) int64 {
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		w.log.Errorf("Error connecting to WebDAV server")
-		w.log.WithError(err)
-		return err
+		return fallback
 	}
+	return parsed
+}
 
-	err = c.MkdirAll(dir, 0755)
+func parseDurationConfig(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
 	if err != nil {
-		return err
+		return fallback
 	}
+	return parsed
+}
 
-	return c.WriteStream(path, body, 0755)
+func parseBoolConfig(value string, fallback bool) bool {
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
 }
 
-func (w *WebDAVObjectStore) ObjectExists(bucket, key string) (bool, error) {
-	path := fmt.Sprintf("%s%s%s%s", w.bucketsDir, bucket, w.delimiter, key)
-	dir, name := SplitPathToDirAndFilename(path)
+// getClient returns the pooled gowebdav.Client, creating and connecting it on
+// first use. Callers share a single underlying http.Transport (and its
+// keep-alive connections) instead of paying for a new TLS handshake and auth
+// round-trip on every object-store operation. Health is not probed eagerly;
+// use withRetry below to reconnect lazily when an operation actually fails.
+func (w *WebDAVObjectStore) getClient() (*gowebdav.Client, error) {
+	w.clientMu.Lock()
+	defer w.clientMu.Unlock()
 
-	c := gowebdav.NewClient(w.root, w.user, w.password)
-	err := c.Connect()
-	if err != nil {
+	if w.client != nil {
+		return w.client, nil
+	}
+
+	c := w.newUnderlyingClient()
+	c.SetTransport(w.requestTransport())
+	c.SetTimeout(w.timeout)
+	if err := c.Connect(); err != nil {
 		w.log.Errorf("Error connecting to WebDAV server")
 		w.log.WithError(err)
-		return false, err
+		return nil, err
+	}
+
+	w.client = c
+	return w.client, nil
+}
+
+// resetClient discards the pooled client so the next getClient call
+// reconnects from scratch.
+func (w *WebDAVObjectStore) resetClient() {
+	w.clientMu.Lock()
+	defer w.clientMu.Unlock()
+	w.client = nil
+}
+
+// withRetry runs op against the pooled client. If op fails with anything
+// other than a "not found" response, the pooled connection is assumed stale:
+// it is discarded and op is retried once against a freshly connected client.
+// The connection is not probed with a PROPFIND before every call.
+func (w *WebDAVObjectStore) withRetry(op func(c *gowebdav.Client) error) error {
+	c, err := w.getClient()
+	if err != nil {
+		return err
 	}
 
-	files, err := c.ReadDir(dir)
+	err = op(c)
+	if err == nil || isNotFound(err) {
+		return err
+	}
+
+	w.log.Warnf("WebDAV operation failed (%v), reconnecting and retrying once", err)
+	w.resetClient()
+
+	c, err = w.getClient()
 	if err != nil {
-		if gowebdav.IsErrNotFound(err) {
-			return false, nil
-		}
-		return false, err
+		return err
 	}
-	for _, file := range files {
-		if !file.IsDir() && file.Name() == name {
-			return true, nil
-		}
+	return op(c)
+}
+
+// httpClient returns a plain *http.Client sharing the pooled transport, for
+// requests (such as a raw Depth: infinity PROPFIND) that gowebdav.Client does
+// not expose a method for.
+func (w *WebDAVObjectStore) httpClient() *http.Client {
+	return &http.Client{Transport: w.requestTransport(), Timeout: w.timeout}
+}
+
+// isNotFound reports whether err represents a missing WebDAV resource,
+// whether it originated from gowebdav or from a raw PROPFIND issued by this
+// package.
+func isNotFound(err error) bool {
+	return gowebdav.IsErrNotFound(err) || errors.Is(err, fs.ErrNotExist)
+}
+
+func SplitPathToDirAndFilename(path string) (dir string, name string) {
+	lastSeparatorI := strings.LastIndex(path, "/")
+	dir, name = "", path
+	if lastSeparatorI != -1 {
+		dir, name = path[:lastSeparatorI], path[lastSeparatorI+1:]
 	}
-	return false, nil
+	return dir, name
 }
 
-func (w *WebDAVObjectStore) GetObject(bucket, key string) (io.ReadCloser, error) {
+func (w *WebDAVObjectStore) PutObject(bucket string, key string, body io.Reader) error {
 	path := fmt.Sprintf("%s%s%s%s", w.bucketsDir, bucket, w.delimiter, key)
+	dir, _ := SplitPathToDirAndFilename(path)
 
-	c := gowebdav.NewClient(w.root, w.user, w.password)
-	err := c.Connect()
+	// MkdirAll carries no request body, so it can be safely retried against a
+	// freshly reconnected client; the body below is only attempted once the
+	// connection is known good.
+	var c *gowebdav.Client
+	err := w.withRetry(func(client *gowebdav.Client) error {
+		c = client
+		return client.MkdirAll(dir, 0755)
+	})
 	if err != nil {
-		w.log.Errorf("Error connecting to WebDAV server")
-		w.log.WithError(err)
-		return nil, err
+		return err
 	}
 
-	return c.ReadStream(path)
+	if w.chunkSizeBytes <= 0 {
+		return c.WriteStream(path, body, 0755)
+	}
+
+	return w.putObjectChunked(c, path, body)
 }
 
-func AddDirsWithCommonPrefixes(w *WebDAVObjectStore, c *gowebdav.Client, accumulatedDirs []string, inputDirs []os.FileInfo, completePrefix string, prefixToCut string, parentDirName string) ([]string, bool, error) {
-	outputAccumulatedDirs := accumulatedDirs
-	allFilesDirs := true
-	var allSubfilesDirs bool
-	for _, currentFile := range inputDirs {
-		completePath := fmt.Sprintf("%s%s/", parentDirName, currentFile.Name())
-		if !strings.HasPrefix(completePath, completePrefix) {
-			continue
-		}
-		commonPrefix, found := strings.CutPrefix(completePath, prefixToCut)
-		if !found {
-			continue
-		}
-		if currentFile.IsDir() {
-			subDirs, err := c.ReadDir(completePath)
-			if err != nil {
-				return outputAccumulatedDirs, allFilesDirs, err
-			}
-			outputAccumulatedDirs, allSubfilesDirs, err = AddDirsWithCommonPrefixes(w, c, outputAccumulatedDirs, subDirs, completePrefix, prefixToCut, completePath)
-			if err != nil {
-				return outputAccumulatedDirs, allFilesDirs, err
+func (w *WebDAVObjectStore) ObjectExists(bucket, key string) (bool, error) {
+	path := fmt.Sprintf("%s%s%s%s", w.bucketsDir, bucket, w.delimiter, key)
+	dir, name := SplitPathToDirAndFilename(path)
+
+	var exists bool
+	err := w.withRetry(func(c *gowebdav.Client) error {
+		files, err := c.ReadDir(dir)
+		if err != nil {
+			if gowebdav.IsErrNotFound(err) {
+				return nil
 			}
-			if !allSubfilesDirs {
-				// only add directory if it contains at least one file (that is not a directory)
-				outputAccumulatedDirs = append(outputAccumulatedDirs, commonPrefix)
+			return err
+		}
+		for _, file := range files {
+			if !file.IsDir() && file.Name() == name {
+				exists = true
+				break
 			}
-		} else {
-			allFilesDirs = false
 		}
-	}
-	return outputAccumulatedDirs, allFilesDirs, nil
+		return nil
+	})
+	return exists, err
 }
 
-func GetAllFiles(w *WebDAVObjectStore, c *gowebdav.Client, accumulatedFiles []string, inputDirs []os.FileInfo, parentDirName string) ([]string, error) {
-	outputAccumulatedFiles := accumulatedFiles
-	for _, currentFile := range inputDirs {
-		completePath := fmt.Sprintf("%s%s/", parentDirName, currentFile.Name())
-		if currentFile.IsDir() {
-			subDirs, err := c.ReadDir(completePath)
-			if err != nil {
-				return outputAccumulatedFiles, err
-			}
-			outputAccumulatedFiles, err = GetAllFiles(w, c, outputAccumulatedFiles, subDirs, completePath)
-			if err != nil {
-				return outputAccumulatedFiles, err
-			}
-		} else {
-			outputAccumulatedFiles = append(outputAccumulatedFiles, completePath)
-		}
-	}
-	return outputAccumulatedFiles, nil
+func (w *WebDAVObjectStore) GetObject(bucket, key string) (io.ReadCloser, error) {
+	path := fmt.Sprintf("%s%s%s%s", w.bucketsDir, bucket, w.delimiter, key)
+
+	var rc io.ReadCloser
+	err := w.withRetry(func(c *gowebdav.Client) error {
+		var err error
+		rc, err = c.ReadStream(path)
+		return err
+	})
+	return rc, err
 }
 
 func DeterminePrefixesFromFilesWithDelimiter(fileList []string, delimiter string, prefixToCut string) []string {
@@ -279,42 +415,52 @@ func (w *WebDAVObjectStore) ListCommonPrefixes(bucket, prefix, delimiter string)
 
 	var dirs []string
 
-	c := gowebdav.NewClient(w.root, w.user, w.password)
-	err := c.Connect()
+	entries, err := w.ListAllUnder(rootDir)
 	if err != nil {
-		w.log.Errorf("Error connecting to WebDAV server")
-		w.log.WithError(err)
-		return dirs, err
-	}
-
-	rootSubdirs, err := c.ReadDir(rootDir)
-	if err != nil {
-		if gowebdav.IsErrNotFound(err) {
+		if isNotFound(err) {
 			// root directory does not currently exists
 			// this is okay, we only create a directory when we first put a file in it
 			return dirs, nil
-		} else {
-			w.log.Errorf("Error reading directory '%s' via WebDAV", rootDir)
-			w.log.WithError(err)
-			return dirs, err
 		}
+		w.log.Errorf("Error listing directory '%s' via WebDAV", rootDir)
+		w.log.WithError(err)
+		return dirs, err
 	}
 
 	if delimiter == "/" {
-		// traverse into all subdirectories
-		dirs, _, err = AddDirsWithCommonPrefixes(w, c, dirs, rootSubdirs, rootDir, prefixToCut, rootDir)
-		if err != nil {
-			w.log.Errorf("Got error reading directories via WebDAV")
-			w.log.WithError(err)
-			return dirs, err
+		// a directory is only interesting if it directly contains at least one
+		// file (that is not a directory), so collect each file's immediate
+		// parent directory
+		dirsWithFiles := make(map[string]bool)
+		for _, entry := range entries {
+			if entry.IsDir {
+				continue
+			}
+			if parent := immediateParentDir(entry.Path); parent != "" {
+				dirsWithFiles[parent] = true
+			}
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir || !dirsWithFiles[entry.Path] {
+				continue
+			}
+			if !strings.HasPrefix(entry.Path, rootDir) {
+				continue
+			}
+			commonPrefix, found := strings.CutPrefix(entry.Path, prefixToCut)
+			if !found {
+				continue
+			}
+			dirs = append(dirs, commonPrefix)
 		}
 	} else {
-		// get all directory names and only return those matching the prefix
-		allFiles, err := GetAllFiles(w, c, dirs, rootSubdirs, rootDir)
-		if err != nil {
-			w.log.Errorf("Got error reading files via WebDAV")
-			w.log.WithError(err)
-			return dirs, err
+		// get all file names and only return those matching the prefix
+		var allFiles []string
+		for _, entry := range entries {
+			if !entry.IsDir {
+				allFiles = append(allFiles, entry.Path)
+			}
 		}
 		dirs = DeterminePrefixesFromFilesWithDelimiter(allFiles, delimiter, prefixToCut)
 	}
@@ -331,35 +477,26 @@ func (w *WebDAVObjectStore) ListObjects(bucket, prefix string) ([]string, error)
 
 	var objects []string
 
-	c := gowebdav.NewClient(w.root, w.user, w.password)
-	err := c.Connect()
-	if err != nil {
-		w.log.Errorf("Error connecting to WebDAV server")
-		w.log.WithError(err)
-		return objects, err
-	}
-
-	files, err := c.ReadDir(path)
+	entries, err := w.ListAllUnder(path)
 	if err != nil {
-		if gowebdav.IsErrNotFound(err) {
+		if isNotFound(err) {
 			return objects, nil
-		} else {
-			w.log.Errorf("Error reading directory '%s' via WebDAV", path)
-			w.log.WithError(err)
-			return objects, err
 		}
+		w.log.Errorf("Error listing directory '%s' via WebDAV", path)
+		w.log.WithError(err)
+		return objects, err
 	}
 
 	prefixToCut := fmt.Sprintf("%s%s%s", w.bucketsDir, bucket, w.delimiter)
-	for _, file := range files {
-		if !file.IsDir() {
-			completePath := fmt.Sprintf("%s%s%s", path, file.Name(), w.delimiter)
-			filenameWithoutBucket, found := strings.CutPrefix(completePath, prefixToCut)
-			if !found {
-				continue
-			}
-			objects = append(objects, filenameWithoutBucket)
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
 		}
+		filenameWithoutBucket, found := strings.CutPrefix(entry.Path, prefixToCut)
+		if !found {
+			continue
+		}
+		objects = append(objects, filenameWithoutBucket)
 	}
 	return objects, nil
 }
@@ -368,32 +505,76 @@ func (w *WebDAVObjectStore) DeleteObject(bucket, key string) error {
 	path := fmt.Sprintf("%s%s%s%s", w.bucketsDir, bucket, w.delimiter, key)
 	dir, _ := SplitPathToDirAndFilename(path)
 
-	c := gowebdav.NewClient(w.root, w.user, w.password)
-	err := c.Connect()
-	if err != nil {
-		w.log.Errorf("Error connecting to WebDAV server")
-		w.log.WithError(err)
-		return err
-	}
+	return w.withRetry(func(c *gowebdav.Client) error {
+		// Remove (and, below, the directory cleanup Remove) are treated as
+		// already-succeeded when the target is merely gone, so a reconnect
+		// retried against a dropped connection whose first attempt actually
+		// succeeded server-side does not get reported as a failed delete.
+		if err := c.Remove(path); err != nil && !isNotFound(err) {
+			return err
+		}
 
-	err = c.Remove(path)
-	if err != nil {
-		return err
-	}
+		files, err := c.ReadDir(dir)
+		if err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
 
-	files, err := c.ReadDir(dir)
-	if err != nil {
-		return err
-	}
+		if len(files) == 0 {
+			if err := c.Remove(dir); err != nil && !isNotFound(err) {
+				return err
+			}
+		}
 
-	if len(files) == 0 {
-		err := c.Remove(dir)
-		if err != nil {
+		return nil
+	})
+}
+
+// CopyObject duplicates an object on the server side using WebDAV's COPY
+// verb, so large backups can be copied without rehydrating the whole stream
+// through the plugin host.
+func (w *WebDAVObjectStore) CopyObject(srcBucket, srcKey, dstBucket, dstKey string) error {
+	srcPath := fmt.Sprintf("%s%s%s%s", w.bucketsDir, srcBucket, w.delimiter, srcKey)
+	dstPath := fmt.Sprintf("%s%s%s%s", w.bucketsDir, dstBucket, w.delimiter, dstKey)
+	dstDir, _ := SplitPathToDirAndFilename(dstPath)
+
+	return w.withRetry(func(c *gowebdav.Client) error {
+		if err := c.MkdirAll(dstDir, 0755); err != nil {
 			return err
 		}
-	}
+		return c.Copy(srcPath, dstPath, true)
+	})
+}
 
-	return nil
+// RenameObject moves an object within a bucket on the server side using
+// WebDAV's MOVE verb, enabling atomic snapshot renames.
+func (w *WebDAVObjectStore) RenameObject(bucket, oldKey, newKey string) error {
+	oldPath := fmt.Sprintf("%s%s%s%s", w.bucketsDir, bucket, w.delimiter, oldKey)
+	newPath := fmt.Sprintf("%s%s%s%s", w.bucketsDir, bucket, w.delimiter, newKey)
+	newDir, _ := SplitPathToDirAndFilename(newPath)
+
+	return w.withRetry(func(c *gowebdav.Client) error {
+		if err := c.MkdirAll(newDir, 0755); err != nil {
+			return err
+		}
+
+		err := c.Rename(oldPath, newPath, true)
+		if err == nil {
+			return nil
+		}
+		if isNotFound(err) {
+			// oldPath may be gone because an earlier attempt already renamed
+			// it before its response reached us; if newPath is there, the
+			// rename already happened and this retry should not report it as
+			// a failure.
+			if _, statErr := c.Stat(newPath); statErr == nil {
+				return nil
+			}
+		}
+		return err
+	})
 }
 
 func (w *WebDAVObjectStore) CreateSignedURL(bucket, key string, ttl time.Duration) (string, error) {
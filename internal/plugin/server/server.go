@@ -0,0 +1,110 @@
+/*
+Copyright 2024 Christoph Raitzig
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package server provides a minimal WebDAV server backed by a local
+// directory. It exists so the plugin can be exercised end-to-end - in CI or
+// in an air-gapped cluster - without standing up a separate WebDAV
+// deployment.
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"golang.org/x/net/webdav"
+)
+
+// methodsRejectedWhenReadOnly are the WebDAV verbs that mutate server state.
+var methodsRejectedWhenReadOnly = map[string]bool{
+	"PUT":       true,
+	"DELETE":    true,
+	"MKCOL":     true,
+	"COPY":      true,
+	"MOVE":      true,
+	"PROPPATCH": true,
+	"LOCK":      true, // LOCK on an unmapped path creates an empty file
+}
+
+// Config configures a Server.
+type Config struct {
+	Dir      string // directory to serve
+	Username string // HTTP basic auth username; auth is disabled only if Username and Password are both empty
+	Password string // HTTP basic auth password; auth is disabled only if Username and Password are both empty
+	ReadOnly bool   // reject any request that would mutate the served directory
+	TLSCert  string // PEM certificate file, enables TLS together with TLSKey
+	TLSKey   string // PEM key file, enables TLS together with TLSCert
+}
+
+// Server serves a local directory over WebDAV.
+type Server struct {
+	config  Config
+	handler http.Handler
+}
+
+// New builds a Server for the given Config. It does not start listening.
+func New(config Config) *Server {
+	var handler http.Handler = &webdav.Handler{
+		FileSystem: webdav.Dir(config.Dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	if config.ReadOnly {
+		handler = rejectMutatingMethods(handler)
+	}
+	if config.Username != "" || config.Password != "" {
+		handler = requireBasicAuth(config.Username, config.Password, handler)
+	}
+	return &Server{config: config, handler: handler}
+}
+
+// Handler returns the http.Handler backing the server, for embedding into a
+// test server or an existing mux.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// ListenAndServe starts the server on addr, serving over TLS if the Config
+// sets TLSCert/TLSKey.
+func (s *Server) ListenAndServe(addr string) error {
+	httpServer := &http.Server{Addr: addr, Handler: s.handler}
+	if s.config.TLSCert != "" && s.config.TLSKey != "" {
+		return httpServer.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
+	}
+	return httpServer.ListenAndServe()
+}
+
+func requireBasicAuth(username, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUsername, gotPassword, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(gotUsername), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(gotPassword), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="webdav"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func rejectMutatingMethods(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if methodsRejectedWhenReadOnly[r.Method] {
+			http.Error(w, "server is read-only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}